@@ -0,0 +1,143 @@
+package connection
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/quentinmit/go-pvaccess/internal/proto"
+)
+
+// defaultPVAPort is the well-known PVA search/beacon UDP port, used when a
+// peer in EPICS_PVA_ADDR_LIST doesn't specify one explicitly.
+const defaultPVAPort = 5076
+
+// Peers is a list of statically-configured unicast PVA peer addresses,
+// used as a fallback when multicast/broadcast search discovery is
+// unreliable (routed networks, VLANs, cloud environments that block
+// multicast). This is the client-side equivalent of the "bootnode" list
+// other peer-discovery protocols bootstrap from.
+type Peers []*net.UDPAddr
+
+// PeersFromEnv builds a Peers list from EPICS_PVA_ADDR_LIST, a
+// whitespace-separated list of "host[:port]" peers (the port defaults to
+// defaultPVAPort when omitted). EPICS_PVA_AUTO_ADDR_LIST, if set to "NO",
+// disables the caller's usual broadcast/multicast discovery and makes
+// this static list the only way peers are found; Serve/clients are
+// expected to check it themselves, since that decision affects whether
+// multicast sockets get opened at all, not just this list.
+func PeersFromEnv() (Peers, error) {
+	list := os.Getenv("EPICS_PVA_ADDR_LIST")
+	if list == "" {
+		return nil, nil
+	}
+	var peers Peers
+	for _, host := range strings.Fields(list) {
+		addr, err := net.ResolveUDPAddr("udp", withDefaultPort(host, defaultPVAPort))
+		if err != nil {
+			return nil, err
+		}
+		peers = append(peers, addr)
+	}
+	return peers, nil
+}
+
+// AutoAddrListFromEnv reports whether EPICS_PVA_AUTO_ADDR_LIST permits
+// falling back to multicast/broadcast discovery in addition to the
+// static peer list. Per the EPICS PVA spec this defaults to YES; it is
+// only disabled by an explicit "NO".
+func AutoAddrListFromEnv() bool {
+	return !strings.EqualFold(os.Getenv("EPICS_PVA_AUTO_ADDR_LIST"), "NO")
+}
+
+func withDefaultPort(host string, port int) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// SendSearchRequestTo unicasts req to addr, duplicating whatever
+// multicast/broadcast send the caller already performed. This is used to
+// reach peers configured via Peers/PeersFromEnv that multicast discovery
+// can't see.
+func SendSearchRequestTo(ctx context.Context, addr *net.UDPAddr, req *proto.SearchRequest) error {
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	c := New(conn, 0)
+	return c.SendApp(ctx, proto.APP_SEARCH_REQUEST, req)
+}
+
+// SendSearchResponseTo unicasts resp to addr over a short-lived UDP
+// connection. It's used to honor SearchRequest.ResponseAddress, which
+// per the PVA v2 spec overrides sending the response back to the
+// request's source address.
+func SendSearchResponseTo(ctx context.Context, addr *net.UDPAddr, resp *proto.SearchResponse) error {
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	c := New(conn, proto.FLAG_FROM_SERVER)
+	return c.SendApp(ctx, proto.APP_SEARCH_RESPONSE, resp)
+}
+
+// DuplicateSearchRequest unicasts req to every address in peers, in
+// addition to whatever multicast/broadcast send the caller already
+// performs, so discovery also reaches servers that are only known
+// through the static peer list (Peers/PeersFromEnv). This is the
+// client-side half of peer-relayed search: a server relays incoming
+// requests to its peers (see internal/search); a client duplicates its
+// outgoing ones the same way. Since a server that receives a duplicate
+// may also receive the multicast copy, a caller that acts on
+// SearchResponses should de-dupe them with a ResponseDeduper, keyed on
+// the response's GUID and SearchInstanceIDs, before acting twice on the
+// same answer. Errors are collected rather than short-circuited, since
+// one unreachable peer shouldn't stop req from reaching the rest.
+func DuplicateSearchRequest(ctx context.Context, peers Peers, req *proto.SearchRequest) []error {
+	var errs []error
+	for _, addr := range peers {
+		if err := SendSearchRequestTo(ctx, addr, req); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// searchResponseKey identifies a SearchResponse for the purposes of
+// de-duplicating replies to the same request arriving over both
+// multicast and one or more unicast Peers.
+type searchResponseKey struct {
+	guid       [12]byte
+	instanceID int32
+}
+
+// ResponseDeduper tracks which (GUID, SearchInstanceID) pairs have
+// already been seen, so a client sending the same SearchRequest to the
+// multicast group and to every configured Peer (DuplicateSearchRequest)
+// doesn't act on the same SearchResponse more than once.
+type ResponseDeduper struct {
+	seen map[searchResponseKey]bool
+}
+
+// NewResponseDeduper returns a ResponseDeduper ready for use.
+func NewResponseDeduper() *ResponseDeduper {
+	return &ResponseDeduper{seen: make(map[searchResponseKey]bool)}
+}
+
+// Seen reports whether a response with the given GUID and
+// SearchInstanceID has already been passed to Seen, and records it if
+// not.
+func (d *ResponseDeduper) Seen(guid [12]byte, instanceID int32) bool {
+	key := searchResponseKey{guid: guid, instanceID: instanceID}
+	if d.seen[key] {
+		return true
+	}
+	d.seen[key] = true
+	return false
+}
@@ -0,0 +1,22 @@
+package connection
+
+import "testing"
+
+func TestResponseDeduperSeen(t *testing.T) {
+	d := NewResponseDeduper()
+	var guidA, guidB [12]byte
+	guidB[0] = 1
+
+	if d.Seen(guidA, 1) {
+		t.Error("first sighting of (guidA, 1) reported as already seen")
+	}
+	if !d.Seen(guidA, 1) {
+		t.Error("second sighting of (guidA, 1) not reported as already seen")
+	}
+	if d.Seen(guidA, 2) {
+		t.Error("(guidA, 2) reported as seen before it was")
+	}
+	if d.Seen(guidB, 1) {
+		t.Error("(guidB, 1) reported as seen before it was: GUID must be part of the key")
+	}
+}
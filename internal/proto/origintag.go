@@ -0,0 +1,26 @@
+package proto
+
+import "github.com/quentinmit/go-pvaccess/pvdata"
+
+// CTRL_ORIGIN_TAG is the PVA v2 control message a server prepends when
+// re-multicasting a unicast SearchRequest to the local multicast group,
+// so other daemons on the same host know which address actually sent
+// it (the sender otherwise looks like the relaying server itself). It
+// is always followed, in the same datagram, by the forwarded
+// SearchRequest it describes.
+const CTRL_ORIGIN_TAG = 0x05
+
+// OriginTagMessage carries the address of the client that originally
+// sent a SearchRequest which is being forwarded from unicast to the
+// local multicast group. It's sent as a CTRL_ORIGIN_TAG control message
+// immediately ahead of the forwarded SearchRequest, in the same
+// datagram.
+type OriginTagMessage struct {
+	// ForwarderAddress is the address the SearchRequest was originally
+	// received from, encoded the same way as SearchRequest.ResponseAddress
+	// (a 128-bit IPv6 address, with IPv4 addresses mapped per RFC 4291).
+	ForwarderAddress [16]byte
+	// ForwarderPort is the UDP port the SearchRequest was originally
+	// received from.
+	ForwarderPort pvdata.PVUShort
+}
@@ -0,0 +1,88 @@
+// Package registry provides a pluggable registry of channel providers, so
+// the pieces of the server that answer "does this channel exist" don't
+// each need to own the channel namespace themselves. Today that's only
+// the UDP search responder (internal/search); this tree has no TCP
+// server/CreateChannelRequest handler yet for the registry to also back,
+// though the API is meant to serve that purpose too once one exists.
+package registry
+
+import "context"
+
+// Provider looks up PVA channels by name. Implementations might back this
+// with an in-memory map, a filesystem, or a callback into user code.
+type Provider interface {
+	// ChannelExists reports whether a channel with the given name exists.
+	ChannelExists(ctx context.Context, name string) bool
+	// ListChannels returns every channel name this provider knows about.
+	ListChannels(ctx context.Context) []string
+}
+
+// Registry fans channel lookups out across any number of Providers, so a
+// server can plug in several channel sources (e.g. an in-memory map
+// alongside a filesystem-backed one) at once.
+type Registry struct {
+	providers []Provider
+}
+
+// New returns a Registry backed by providers.
+func New(providers ...Provider) *Registry {
+	return &Registry{providers: providers}
+}
+
+// Register adds provider to the registry.
+func (r *Registry) Register(provider Provider) {
+	r.providers = append(r.providers, provider)
+}
+
+// ChannelExists reports whether any registered provider has a channel
+// with the given name.
+func (r *Registry) ChannelExists(ctx context.Context, name string) bool {
+	for _, p := range r.providers {
+		if p.ChannelExists(ctx, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// ListChannels returns the union of every registered provider's channels.
+func (r *Registry) ListChannels(ctx context.Context) []string {
+	var names []string
+	for _, p := range r.providers {
+		names = append(names, p.ListChannels(ctx)...)
+	}
+	return names
+}
+
+// MapProvider is a Provider backed by a fixed, in-memory set of channel
+// names.
+type MapProvider map[string]bool
+
+// ChannelExists implements Provider.
+func (m MapProvider) ChannelExists(ctx context.Context, name string) bool {
+	return m[name]
+}
+
+// ListChannels implements Provider.
+func (m MapProvider) ListChannels(ctx context.Context) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	return names
+}
+
+// ExistsFunc adapts a function to a Provider whose ListChannels is always
+// empty, for callers who only want to answer ChannelExists (e.g. proxying
+// to an external source that doesn't support enumeration).
+type ExistsFunc func(ctx context.Context, name string) bool
+
+// ChannelExists implements Provider.
+func (f ExistsFunc) ChannelExists(ctx context.Context, name string) bool {
+	return f(ctx, name)
+}
+
+// ListChannels implements Provider.
+func (f ExistsFunc) ListChannels(ctx context.Context) []string {
+	return nil
+}
@@ -0,0 +1,96 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"net"
+
+	"github.com/quentinmit/go-pvaccess/internal/connection"
+	"github.com/quentinmit/go-pvaccess/internal/proto"
+	"github.com/quentinmit/go-pvaccess/pvdata"
+)
+
+// localMulticastGroup is the loopback-scoped multicast group other PVA
+// daemons on the same host listen on. Re-multicasting unicast search
+// requests there is what lets single-host multi-daemon setups (the norm
+// for EPICS IOCs) see each other's traffic.
+const localMulticastGroup = "224.0.0.128:5076"
+
+// forwarder re-multicasts unicast SearchRequests to localMulticastGroup,
+// per the PVA v2 unicast-forwarding rule. It's bound to loopback since
+// the group itself is loopback-scoped.
+type forwarder struct {
+	conn *net.UDPConn
+}
+
+func newForwarder() (*forwarder, error) {
+	laddr, err := net.ResolveUDPAddr("udp4", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	raddr, err := net.ResolveUDPAddr("udp4", localMulticastGroup)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp4", laddr, raddr)
+	if err != nil {
+		return nil, err
+	}
+	return &forwarder{conn: conn}, nil
+}
+
+func (f *forwarder) Close() error {
+	return f.conn.Close()
+}
+
+// isSelf reports whether addr is f's own outgoing socket. With
+// IP_MULTICAST_LOOP set, a forwarded packet comes back to this same
+// daemon's listeners with addr equal to f.conn.LocalAddr(); handleConnection
+// uses this to recognize and drop its own loopback copy instead of
+// answering the request a second time.
+func (f *forwarder) isSelf(addr *net.UDPAddr) bool {
+	local, ok := f.conn.LocalAddr().(*net.UDPAddr)
+	return ok && addr != nil && local.Port == addr.Port && local.IP.Equal(addr.IP)
+}
+
+// batchWriter buffers everything written to it instead of passing writes
+// through to the wrapped net.Conn, and flushes the buffer as a single
+// datagram on flush. forward uses it so the CTRL_ORIGIN_TAG control
+// message and the SearchRequest that follows land in the same packet: a
+// receiver decodes exactly one message per accepted datagram, so the
+// tag and the request it describes must travel together.
+type batchWriter struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (w *batchWriter) Write(b []byte) (int, error) { return w.buf.Write(b) }
+func (w *batchWriter) flush() error {
+	_, err := w.Conn.Write(w.buf.Bytes())
+	return err
+}
+
+// forward clears req's unicast flag and re-sends it to
+// localMulticastGroup, prefixed with a CTRL_ORIGIN_TAG control message
+// carrying origin, the address the request actually arrived from, so
+// other local daemons don't mistake the forwarder for the original
+// sender.
+func (f *forwarder) forward(ctx context.Context, req *proto.SearchRequest, origin *net.UDPAddr) error {
+	w := &batchWriter{Conn: f.conn}
+	c := connection.New(w, proto.FLAG_FROM_SERVER)
+	c.Version = pvdata.PVByte(2)
+
+	var tag proto.OriginTagMessage
+	copy(tag.ForwarderAddress[:], origin.IP.To16())
+	tag.ForwarderPort = pvdata.PVUShort(origin.Port)
+	if err := c.SendControl(ctx, proto.CTRL_ORIGIN_TAG, &tag); err != nil {
+		return err
+	}
+
+	forwarded := *req
+	forwarded.Flags &^= proto.SEARCH_UNICAST
+	if err := c.SendApp(ctx, proto.APP_SEARCH_REQUEST, &forwarded); err != nil {
+		return err
+	}
+	return w.flush()
+}
@@ -0,0 +1,194 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/net/ipv6"
+
+	"github.com/quentinmit/go-pvaccess/internal/connection"
+	"github.com/quentinmit/go-pvaccess/internal/ctxlog"
+	"github.com/quentinmit/go-pvaccess/internal/proto"
+	"github.com/quentinmit/go-pvaccess/pvdata"
+)
+
+// pvaV6Group is the link-local IPv6 multicast group beacons and search
+// requests are sent to, per the PVA v2 protocol.
+const pvaV6Group = "ff02::cafe:beef"
+
+const pvaPort = 5076
+
+const maxDatagramSize = 64 * 1024
+
+// v6Listener is a single UDP socket bound to one network interface's
+// link-local address, joined to the PVA IPv6 multicast group scoped to
+// that interface. Replies to requests received on this socket, and
+// beacons sent from it, both go out with that interface's source
+// address.
+type v6Listener struct {
+	iface *net.Interface
+	// addr is iface's own IPv6 address, reported to requesters in
+	// SearchResponse.ServerAddress. It can't be read back off conn:
+	// every v6Listener shares the same bind address (":5076", via
+	// SO_REUSEPORT in listenConfig), so conn.LocalAddr() is always the
+	// unspecified address, not the interface's real one.
+	addr net.IP
+	pc   *ipv6.PacketConn
+	conn *net.UDPConn
+}
+
+// usableV6Interfaces returns the interfaces we should open a per-interface
+// multicast socket on: up, multicast-capable, and not loopback-only.
+func usableV6Interfaces() ([]net.Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var usable []net.Interface
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		if iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		if iface.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		usable = append(usable, iface)
+	}
+	return usable, nil
+}
+
+// interfaceV6Addr returns the IPv6 address iface's traffic should be
+// reported under: its link-local address, since that's the address
+// scoped multicast traffic on this group actually uses as a source, or
+// failing that its first global unicast address.
+func interfaceV6Addr(iface net.Interface) (net.IP, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var global net.IP
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok || ipnet.IP.To4() != nil {
+			continue
+		}
+		if ipnet.IP.IsLinkLocalUnicast() {
+			return ipnet.IP, nil
+		}
+		if global == nil {
+			global = ipnet.IP
+		}
+	}
+	if global != nil {
+		return global, nil
+	}
+	return nil, fmt.Errorf("no IPv6 address on %s", iface.Name)
+}
+
+// listenV6Interface opens a UDP socket for PVA search/beacon traffic on
+// iface and joins the PVA IPv6 multicast group scoped to that interface's
+// index (IPV6_JOIN_GROUP), so replies and beacons can be sent with the
+// correct link-local source address (IPV6_MULTICAST_IF).
+//
+// Every usable interface binds the same wildcard address:port, which is
+// only possible because listenConfig sets SO_REUSEADDR/SO_REUSEPORT;
+// without it, every listenV6Interface call after the first would fail
+// with "address already in use".
+func listenV6Interface(ctx context.Context, iface net.Interface) (*v6Listener, error) {
+	addr, err := interfaceV6Addr(iface)
+	if err != nil {
+		return nil, err
+	}
+
+	group := net.ParseIP(pvaV6Group)
+
+	pconn, err := listenConfig.ListenPacket(ctx, "udp6", fmt.Sprintf(":%d", pvaPort))
+	if err != nil {
+		return nil, err
+	}
+	conn := pconn.(*net.UDPConn)
+	pc := ipv6.NewPacketConn(conn)
+	if err := pc.JoinGroup(&iface, &net.UDPAddr{IP: group}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := pc.SetMulticastInterface(&iface); err != nil {
+		ctxlog.L(ctx).Warnf("search: IPV6_MULTICAST_IF failed on %s: %v", iface.Name, err)
+	}
+	pc.SetMulticastLoopback(true)
+
+	return &v6Listener{iface: &iface, addr: addr, pc: pc, conn: conn}, nil
+}
+
+func (l *v6Listener) Close() error {
+	return l.conn.Close()
+}
+
+// serve reads datagrams off the socket until it's closed or ctx is done,
+// handing each one to srv.handleConnection as its own one-shot net.Conn.
+func (l *v6Listener) serve(ctx context.Context, srv *searchServer) error {
+	laddr := &net.UDPAddr{IP: l.addr, Port: pvaPort, Zone: l.iface.Name}
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, raddr, err := l.conn.ReadFromUDP(buf)
+		if err != nil {
+			return err
+		}
+		b := make([]byte, n)
+		copy(b, buf[:n])
+		c := &v6Conn{
+			Reader: bytes.NewReader(b),
+			laddr:  laddr,
+			raddr:  raddr,
+			l:      l,
+		}
+		go srv.handleConnection(ctx, laddr, c)
+	}
+}
+
+// sendBeacon sends beacon out through this interface's socket to the PVA
+// IPv6 multicast group, so it carries that interface's source address.
+func (l *v6Listener) sendBeacon(ctx context.Context, beacon *proto.BeaconMessage) error {
+	dst := &net.UDPAddr{IP: net.ParseIP(pvaV6Group), Port: pvaPort, Zone: l.iface.Name}
+	c := connection.New(&v6Conn{raddr: dst, l: l}, proto.FLAG_FROM_SERVER)
+	c.Version = pvdata.PVByte(2)
+	return c.SendApp(ctx, proto.APP_BEACON, beacon)
+}
+
+// sendTo sends b to dst, making sure the packet leaves through this
+// listener's interface (and therefore with its link-local source
+// address) rather than whatever route the kernel would otherwise pick.
+func (l *v6Listener) sendTo(b []byte, dst *net.UDPAddr) error {
+	_, err := l.pc.WriteTo(b, &ipv6.ControlMessage{IfIndex: l.iface.Index}, dst)
+	return err
+}
+
+// v6Conn adapts a single already-received UDP datagram, plus the
+// v6Listener it arrived on, to the net.Conn interface connection.New
+// expects. Writes (i.e. search responses) are sent back out through the
+// same per-interface socket the request arrived on.
+type v6Conn struct {
+	*bytes.Reader
+	laddr, raddr *net.UDPAddr
+	l            *v6Listener
+}
+
+func (c *v6Conn) Write(b []byte) (int, error) {
+	if err := c.l.sendTo(b, c.raddr); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *v6Conn) Close() error                       { return nil }
+func (c *v6Conn) LocalAddr() net.Addr                { return c.laddr }
+func (c *v6Conn) RemoteAddr() net.Addr               { return c.raddr }
+func (c *v6Conn) SetDeadline(t time.Time) error      { return nil }
+func (c *v6Conn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *v6Conn) SetWriteDeadline(t time.Time) error { return nil }
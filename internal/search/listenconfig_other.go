@@ -0,0 +1,12 @@
+//go:build !unix
+
+package search
+
+import "net"
+
+// listenConfig has no SO_REUSEPORT equivalent on this platform, so only
+// the first usable interface's listenV6Interface call will succeed; the
+// rest fail with "address already in use" and are logged and skipped by
+// v6Manager.rescan, the same way an interface with no IPv6 multicast
+// support is.
+var listenConfig = net.ListenConfig{}
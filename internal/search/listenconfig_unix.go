@@ -0,0 +1,29 @@
+//go:build unix
+
+package search
+
+import (
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// listenConfig sets SO_REUSEADDR and SO_REUSEPORT on the socket before it's
+// bound, so that every usable interface can bind its own listener to the
+// same wildcard address:port pair instead of fighting over it.
+var listenConfig = net.ListenConfig{
+	Control: func(network, address string, c syscall.RawConn) error {
+		var setErr error
+		if err := c.Control(func(fd uintptr) {
+			if err := unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
+				setErr = err
+				return
+			}
+			setErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+		}); err != nil {
+			return err
+		}
+		return setErr
+	},
+}
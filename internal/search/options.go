@@ -0,0 +1,87 @@
+package search
+
+import (
+	"net"
+
+	"github.com/quentinmit/go-pvaccess/internal/connection"
+	"github.com/quentinmit/go-pvaccess/internal/registry"
+)
+
+// Option configures optional behavior of Serve.
+type Option func(*options)
+
+type options struct {
+	peers        connection.Peers
+	providers    []registry.Provider
+	autoAddrList bool
+
+	otlpEndpoint string
+	serviceName  string
+}
+
+// WithOTLPEndpoint configures Serve to export traces and metrics to the
+// given OTLP gRPC endpoint (host:port). If unset, Serve falls back to
+// OTEL_EXPORTER_OTLP_ENDPOINT, and if that's unset too, tracing/metrics
+// are reported through the global otel providers, which are no-ops until
+// something else in the process configures them.
+func WithOTLPEndpoint(endpoint string) Option {
+	return func(o *options) {
+		o.otlpEndpoint = endpoint
+	}
+}
+
+// WithServiceName sets the service.name resource attribute reported
+// alongside traces and metrics when an OTLP endpoint is configured. If
+// unset, Serve falls back to OTEL_SERVICE_NAME, then "go-pvaccess".
+func WithServiceName(name string) Option {
+	return func(o *options) {
+		o.serviceName = name
+	}
+}
+
+// WithChannelProvider registers a channel provider that search responses
+// consult to decide whether a requested channel exists. Multiple
+// providers may be registered; a channel is considered found if any of
+// them report it.
+func WithChannelProvider(provider registry.Provider) Option {
+	return func(o *options) {
+		o.providers = append(o.providers, provider)
+	}
+}
+
+// WithPeers adds statically-configured unicast PVA peer addresses that
+// Serve relays incoming search requests to, in addition to whatever it
+// picks up from EPICS_PVA_ADDR_LIST. This lets callers bootstrap
+// discovery on networks where multicast/broadcast doesn't reach, the
+// same way EPICS_PVA_ADDR_LIST does for the reference client.
+func WithPeers(addrs ...*net.UDPAddr) Option {
+	return func(o *options) {
+		o.peers = append(o.peers, addrs...)
+	}
+}
+
+// WithAutoAddrList overrides EPICS_PVA_AUTO_ADDR_LIST. When disabled,
+// Serve sends no beacons and opens no per-interface IPv6 multicast
+// sockets. It still listens for search requests on the IPv4 sockets
+// udpconn.Listen opens, answers them, and relays them to the
+// statically-configured peer list (WithPeers/EPICS_PVA_ADDR_LIST).
+func WithAutoAddrList(enabled bool) Option {
+	return func(o *options) {
+		o.autoAddrList = enabled
+	}
+}
+
+func newOptions(opts ...Option) (*options, error) {
+	o := &options{
+		autoAddrList: connection.AutoAddrListFromEnv(),
+	}
+	envPeers, err := connection.PeersFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	o.peers = append(o.peers, envPeers...)
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o, nil
+}
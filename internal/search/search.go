@@ -4,13 +4,20 @@ package search
 import (
 	"context"
 	"crypto/rand"
+	"fmt"
 	"io"
 	"net"
+	"sync"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+
 	"github.com/quentinmit/go-pvaccess/internal/connection"
 	"github.com/quentinmit/go-pvaccess/internal/ctxlog"
 	"github.com/quentinmit/go-pvaccess/internal/proto"
+	"github.com/quentinmit/go-pvaccess/internal/registry"
 	"github.com/quentinmit/go-pvaccess/internal/udpconn"
 	"github.com/quentinmit/go-pvaccess/pvdata"
 )
@@ -25,10 +32,36 @@ const startupCount = 15
 // TODO: EPICS_PVA_BEACON_PERIOD environment variable
 const beaconInterval = 5 * time.Second
 
+// interfaceRescanInterval controls how often Serve re-scans the machine's
+// network interfaces so that hot-plugged NICs (and NICs that come up after
+// Serve starts, e.g. a VPN link) pick up IPv6 multicast beacons and search
+// traffic without a restart.
+const interfaceRescanInterval = 30 * time.Second
+
 // Serve transmits beacons and listens for searches on every interface on the machine.
 // If serverAddr specifies an IP, beacons will advertise that address.
 // If it does not, beacons will advertise the address of the interface they are transmitted on.
-func Serve(ctx context.Context, serverAddr *net.TCPAddr) error {
+//
+// Peers configured via WithPeers and/or EPICS_PVA_ADDR_LIST are used as a
+// unicast fallback: incoming search requests are relayed to them so a
+// locally-known gateway can proxy discovery across networks multicast
+// can't reach.
+func Serve(ctx context.Context, serverAddr *net.TCPAddr, opts ...Option) error {
+	o, err := newOptions(opts...)
+	if err != nil {
+		return err
+	}
+
+	tel, err := newTelemetry(ctx, o)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tel.shutdown(context.Background()); err != nil {
+			ctxlog.L(ctx).Warnf("search: shutting down telemetry: %v", err)
+		}
+	}()
+
 	var beacon proto.BeaconMessage
 	if _, err := rand.Read(beacon.GUID[:]); err != nil {
 		return err
@@ -49,6 +82,11 @@ func Serve(ctx context.Context, serverAddr *net.TCPAddr) error {
 	// One socket listening on 224.0.0.128 on lo
 	//   Listen on 224.0.0.128:5076
 	//   IP_ADD_MEMBERSHIP 224.0.0.128, 127.0.0.1
+	//
+	// IPv6 is handled separately below: since there's no IPv6 broadcast
+	// equivalent and multicast scope is tied to a specific interface, we
+	// open one socket per usable interface and join ff02::cafe:beef
+	// scoped to that interface's index.
 
 	ln, err := udpconn.Listen(ctx)
 	if err != nil {
@@ -60,15 +98,39 @@ func Serve(ctx context.Context, serverAddr *net.TCPAddr) error {
 
 	ctxlog.L(ctx).Infof("sending beacons to %v", ln.BroadcastSendAddresses())
 
+	fwd, err := newForwarder()
+	if err != nil {
+		return err
+	}
+	defer fwd.Close()
+
+	srv := &searchServer{
+		GUID:       beacon.GUID,
+		ServerPort: serverAddr.Port,
+		peers:      o.peers,
+		registry:   registry.New(o.providers...),
+		telemetry:  tel,
+		fwd:        fwd,
+	}
+
 	go func() {
-		if err := (&searchServer{
-			GUID:       beacon.GUID,
-			ServerPort: serverAddr.Port,
-		}).serve(ctx, ln); err != nil && err != io.EOF {
+		if err := srv.serve(ctx, ln); err != nil && err != io.EOF {
 			ctxlog.L(ctx).Errorf("failed handling search request: %v", err)
 		}
 	}()
 
+	v6 := newV6Manager(ctx, srv)
+	defer v6.Close()
+	if o.autoAddrList {
+		if err := v6.rescan(ctx); err != nil {
+			ctxlog.L(ctx).Warnf("search: no IPv6 multicast interfaces available, falling back to IPv4 broadcast only: %v", err)
+		}
+	} else {
+		ctxlog.L(ctx).Infof("search: EPICS_PVA_AUTO_ADDR_LIST=NO, relying on the static peer list only")
+	}
+	rescanTicker := time.NewTicker(interfaceRescanInterval)
+	defer rescanTicker.Stop()
+
 	ticker := time.NewTicker(startupInterval)
 	defer func() { ticker.Stop() }()
 	i := 0
@@ -76,9 +138,19 @@ func Serve(ctx context.Context, serverAddr *net.TCPAddr) error {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
+		case <-rescanTicker.C:
+			if !o.autoAddrList {
+				continue
+			}
+			if err := v6.rescan(ctx); err != nil {
+				ctxlog.L(ctx).Warnf("search: rescanning IPv6 interfaces: %v", err)
+			}
 		case <-ticker.C:
 			beacon.BeaconSequenceID++
-			beaconSender.SendApp(ctx, proto.APP_BEACON, &beacon)
+			if o.autoAddrList {
+				srv.sendBeacon(ctx, beaconSender, &beacon, ln.BroadcastSendAddresses())
+				v6.sendBeacon(ctx, &beacon)
+			}
 			i++
 			if i == startupCount {
 				ticker.Stop()
@@ -88,9 +160,51 @@ func Serve(ctx context.Context, serverAddr *net.TCPAddr) error {
 	}
 }
 
+// searchServer answers PVA search requests and, together with v6Manager,
+// tracks enough state to reply through the same socket (and therefore the
+// same interface/source address) a request arrived on.
 type searchServer struct {
 	GUID       [12]byte
 	ServerPort int
+
+	// peers are statically-configured unicast addresses (WithPeers,
+	// EPICS_PVA_ADDR_LIST) that incoming search requests are relayed to,
+	// so a locally-known gateway can proxy discovery.
+	peers connection.Peers
+
+	// registry answers whether a requested channel actually exists, so
+	// search responses can report Found=true. Only the UDP search
+	// responder consults it; there is no TCP CreateChannelRequest handler
+	// in this tree using it.
+	registry *registry.Registry
+
+	// telemetry reports beacon and search lifecycle spans/metrics. It's
+	// never nil; newTelemetry falls back to the no-op global providers.
+	telemetry *telemetry
+
+	// fwd re-multicasts unicast search requests to the local multicast
+	// group so other daemons on the same host see them too.
+	fwd *forwarder
+}
+
+// sendBeacon sends beacon to dests over sender inside a span covering the
+// whole burst, and records the beacons_sent_total metric.
+func (s *searchServer) sendBeacon(ctx context.Context, sender *connection.Conn, beacon *proto.BeaconMessage, dests interface{}) {
+	ctx, span := s.telemetry.tracer.Start(ctx, "search.beacon",
+		trace.WithAttributes(
+			attribute.String("guid", fmt.Sprintf("%x", beacon.GUID)),
+			attribute.Int64("sequence_id", int64(beacon.BeaconSequenceID)),
+			attribute.String("destinations", fmt.Sprint(dests)),
+		),
+	)
+	defer span.End()
+
+	if err := sender.SendApp(ctx, proto.APP_BEACON, beacon); err != nil {
+		span.RecordError(err)
+		s.telemetry.interfaceErrors.Add(ctx, 1, metric.WithAttributes(attribute.String("reason", "ipv4_broadcast")))
+		return
+	}
+	s.telemetry.beaconsSent.Add(ctx, 1)
 }
 
 func (s *searchServer) serve(ctx context.Context, ln *udpconn.Listener) (err error) {
@@ -106,56 +220,307 @@ func (s *searchServer) serve(ctx context.Context, ln *udpconn.Listener) (err err
 			return err
 		}
 		laddr := conn.LocalAddr()
-		ctx = ctxlog.WithField(ctx, "local_addr", laddr)
+		ctx := ctxlog.WithField(ctx, "local_addr", laddr)
 		go s.handleConnection(ctx, ln.LocalAddr(), conn)
 	}
 }
 
-func (s *searchServer) handleConnection(ctx context.Context, laddr *net.UDPAddr, conn *udpconn.Conn) (err error) {
+// handleConnection decodes and answers a single inbound search request.
+// conn is whatever socket (wildcard/broadcast or a per-interface IPv6
+// listener) the request arrived on; replies are written back through the
+// same conn so they leave with a source address the requester can reach,
+// unless the request names a ResponseAddress or carries an origin tag, in
+// which case sendResponse unicasts them over a separate socket.
+func (s *searchServer) handleConnection(ctx context.Context, laddr *net.UDPAddr, conn net.Conn) (err error) {
+	start := time.Now()
+	ctx, span := s.telemetry.tracer.Start(ctx, "search.handle_datagram",
+		trace.WithAttributes(
+			attribute.String("remote_addr", fmt.Sprint(conn.RemoteAddr())),
+			attribute.String("local_addr", fmt.Sprint(laddr)),
+		),
+	)
+	defer span.End()
+
 	defer func() {
 		if err != nil && err != io.EOF {
+			span.RecordError(err)
 			ctxlog.L(ctx).Warnf("error handling UDP packet: %v", err)
 		}
 	}()
 	defer conn.Close()
 
-	ctx = ctxlog.WithField(ctx, "remote_addr", conn.Addr())
+	ctx = ctxlog.WithField(ctx, "remote_addr", conn.RemoteAddr())
+
+	if raddr, ok := conn.RemoteAddr().(*net.UDPAddr); ok && s.fwd.isSelf(raddr) {
+		// Our own forward() copy, looped back to us by IP_MULTICAST_LOOP
+		// on the local group. The handler that forwarded it answers the
+		// request directly right after forwarding; other daemons on the
+		// host still need to see it, but we must not answer it a second time.
+		return io.EOF
+	}
 
 	c := connection.New(conn, proto.FLAG_FROM_SERVER)
 	c.Version = pvdata.PVByte(2)
+	decodeStart := time.Now()
 	msg, err := c.Next(ctx)
 	if err != nil {
 		return err
 	}
 
+	// A request relayed in from another local daemon is prefixed, in the
+	// same datagram, with a CTRL_ORIGIN_TAG naming the client it really
+	// came from; consume it before decoding the SearchRequest it describes.
+	var origin *net.UDPAddr
+	if msg.Header.MessageCommand == proto.CTRL_ORIGIN_TAG {
+		var tag proto.OriginTagMessage
+		if err := msg.Decode(&tag); err != nil {
+			return err
+		}
+		origin = &net.UDPAddr{IP: net.IP(tag.ForwarderAddress[:]), Port: int(tag.ForwarderPort)}
+		ctx = ctxlog.WithField(ctx, "origin_addr", origin)
+		if msg, err = c.Next(ctx); err != nil {
+			return err
+		}
+	}
+
+	span.SetAttributes(
+		attribute.Int64("message_command", int64(msg.Header.MessageCommand)),
+		attribute.Float64("decode_duration_seconds", time.Since(decodeStart).Seconds()),
+	)
+
 	if msg.Header.MessageCommand == proto.APP_SEARCH_REQUEST {
 		var req proto.SearchRequest
 		if err := msg.Decode(&req); err != nil {
 			return err
 		}
 		ctxlog.L(ctx).Debugf("search request received: %#v", req)
-		// Process search
-		// TODO: Send to local multicast group for other local apps
-		// TODO: Clear unicast flag, set response address to raddr if unset, add origin tag prefix,
-		resp := &proto.SearchResponse{
+		span.SetAttributes(attribute.Int("channels_searched", len(req.Channels)))
+
+		// A request relayed in from another local daemon (origin != nil)
+		// was already relayed to peers and forwarded by whichever daemon
+		// received it first; don't repeat either step.
+		if origin == nil {
+			raddr, _ := conn.RemoteAddr().(*net.UDPAddr)
+			s.relayToPeers(ctx, &req, raddr)
+
+			// A unicast request must be re-multicast to the local group
+			// (with an origin tag identifying its real sender) so other
+			// daemons on this host see it too.
+			if req.Flags&proto.SEARCH_UNICAST == proto.SEARCH_UNICAST && raddr != nil {
+				if err := s.fwd.forward(ctx, &req, raddr); err != nil {
+					ctxlog.L(ctx).Warnf("search: forwarding unicast search request to %s: %v", localMulticastGroup, err)
+				}
+			}
+		}
+
+		base := proto.SearchResponse{
 			GUID:             s.GUID,
 			SearchSequenceID: req.SearchSequenceID,
 			ServerPort:       pvdata.PVUShort(s.ServerPort),
 			Protocol:         "tcp",
 		}
-		copy(resp.ServerAddress[:], []byte(laddr.IP.To16()))
-		var found []pvdata.PVUInt
-		// TODO: Find channels
-		if len(found) == 0 {
-			resp.Found = false
-			for _, channel := range req.Channels {
-				resp.SearchInstanceIDs = append(resp.SearchInstanceIDs, channel.SearchInstanceID)
+		copy(base.ServerAddress[:], []byte(laddr.IP.To16()))
+
+		found, notFound, sendNotFound := splitChannels(ctx, s.registry, &req)
+
+		span.SetAttributes(attribute.Int("channels_found", len(found)))
+		foundAttr := metric.WithAttributes(attribute.Bool("found", len(found) > 0))
+		s.telemetry.searchRequests.Add(ctx, 1, foundAttr)
+		defer func() {
+			s.telemetry.searchLatency.Record(ctx, time.Since(start).Seconds(), foundAttr)
+		}()
+
+		// The PVA spec requires a separate response for the found and
+		// not-found channels when a request is a mix of both, rather than
+		// one response with a misleading single Found value.
+		if len(found) > 0 {
+			resp := base
+			resp.Found = true
+			resp.SearchInstanceIDs = found
+			if err := s.sendResponse(ctx, c, origin, &req, &resp); err != nil {
+				ctxlog.L(ctx).Warnf("search: sending search response: %v", err)
 			}
 		}
-		if len(found) > 0 || req.Flags&proto.SEARCH_REPLY_REQUIRED == proto.SEARCH_REPLY_REQUIRED {
-			c.SendApp(ctx, proto.APP_SEARCH_RESPONSE, resp)
-			// TODO: Send response to req.ResponseAddr if set
+		if sendNotFound {
+			resp := base
+			resp.Found = false
+			resp.SearchInstanceIDs = notFound
+			if err := s.sendResponse(ctx, c, origin, &req, &resp); err != nil {
+				ctxlog.L(ctx).Warnf("search: sending search response: %v", err)
+			}
 		}
 	}
 	return io.EOF
 }
+
+// sendResponse sends resp for req, honoring req.ResponseAddress: per the
+// PVA v2 spec, a non-zero ResponseAddress means the response should go
+// there directly instead of back to fallback. fallback is nil for a
+// request decoded straight off conn (in which case writing through c
+// already addresses the reply correctly), and the original client's
+// address for a request relayed in from another local daemon via an
+// origin tag, whose conn is that daemon's loopback socket rather than
+// the client's.
+func (s *searchServer) sendResponse(ctx context.Context, c *connection.Conn, fallback *net.UDPAddr, req *proto.SearchRequest, resp *proto.SearchResponse) error {
+	dest := responseAddr(req)
+	if dest == nil {
+		dest = fallback
+	}
+	var err error
+	if dest != nil {
+		err = connection.SendSearchResponseTo(ctx, dest, resp)
+	} else {
+		err = c.SendApp(ctx, proto.APP_SEARCH_RESPONSE, resp)
+	}
+	if err != nil {
+		s.telemetry.interfaceErrors.Add(ctx, 1, metric.WithAttributes(attribute.String("reason", "search_response")))
+	}
+	return err
+}
+
+// responseAddr returns the address req.ResponseAddress/ResponsePort
+// specify, or nil if ResponseAddress is unset (all zero), meaning the
+// response should go back to the request's source address instead.
+func responseAddr(req *proto.SearchRequest) *net.UDPAddr {
+	var zero [16]byte
+	if req.ResponseAddress == zero {
+		return nil
+	}
+	return &net.UDPAddr{IP: net.IP(req.ResponseAddress[:]), Port: int(req.ResponsePort)}
+}
+
+// splitChannels partitions req's channels into found and not-found
+// SearchInstanceIDs using reg, and reports whether a not-found response
+// should actually be sent. Per the PVA v2 spec, a server only has to
+// report channels it couldn't find when the requester set
+// SEARCH_REPLY_REQUIRED; otherwise it stays silent for them, so a
+// multicast search for a channel hosted by one daemon out of many
+// doesn't turn into a not-found reply from every daemon that misses.
+func splitChannels(ctx context.Context, reg *registry.Registry, req *proto.SearchRequest) (found, notFound []pvdata.PVUInt, sendNotFound bool) {
+	for _, channel := range req.Channels {
+		if reg.ChannelExists(ctx, string(channel.ChannelName)) {
+			found = append(found, channel.SearchInstanceID)
+		} else {
+			notFound = append(notFound, channel.SearchInstanceID)
+		}
+	}
+	replyRequired := req.Flags&proto.SEARCH_REPLY_REQUIRED == proto.SEARCH_REPLY_REQUIRED
+	sendNotFound = len(notFound) > 0 && replyRequired
+	return found, notFound, sendNotFound
+}
+
+// relayToPeers unicasts req to every statically-configured peer, so a
+// locally-known gateway that isn't reachable by multicast still sees
+// search traffic. SendSearchRequestTo relays over a short-lived socket
+// that's gone the moment it returns, so if req doesn't already specify
+// a ResponseAddress, it's rewritten to requester before relaying, so
+// the peer's SearchResponse (sent via SendSearchResponseTo, honoring
+// ResponseAddress) reaches the real client instead of that torn-down
+// socket. Errors are logged, not returned, since a single unreachable
+// peer shouldn't stop the request from being answered locally.
+func (s *searchServer) relayToPeers(ctx context.Context, req *proto.SearchRequest, requester *net.UDPAddr) {
+	if len(s.peers) == 0 {
+		return
+	}
+	relayed := *req
+	if requester != nil && responseAddr(&relayed) == nil {
+		copy(relayed.ResponseAddress[:], requester.IP.To16())
+		relayed.ResponsePort = pvdata.PVUShort(requester.Port)
+	}
+	for _, addr := range s.peers {
+		if err := connection.SendSearchRequestTo(ctx, addr, &relayed); err != nil {
+			ctxlog.L(ctx).Warnf("search: relaying search request to peer %v: %v", addr, err)
+		}
+	}
+}
+
+// v6Manager owns one v6Listener per usable network interface and keeps
+// that set in sync with the machine's interfaces over time.
+type v6Manager struct {
+	srv *searchServer
+
+	mu        sync.Mutex
+	listeners map[int]*v6Listener // keyed by net.Interface.Index
+}
+
+func newV6Manager(ctx context.Context, srv *searchServer) *v6Manager {
+	return &v6Manager{
+		srv:       srv,
+		listeners: make(map[int]*v6Listener),
+	}
+}
+
+// rescan opens sockets for any usable interface we don't already have one
+// for, and tears down sockets for interfaces that disappeared or went
+// down since the last scan.
+func (m *v6Manager) rescan(ctx context.Context) error {
+	ifaces, err := usableV6Interfaces()
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[int]bool, len(ifaces))
+	for _, iface := range ifaces {
+		seen[iface.Index] = true
+
+		m.mu.Lock()
+		_, ok := m.listeners[iface.Index]
+		m.mu.Unlock()
+		if ok {
+			continue
+		}
+
+		l, err := listenV6Interface(ctx, iface)
+		if err != nil {
+			ctxlog.L(ctx).Warnf("search: not joining IPv6 multicast group on %s: %v", iface.Name, err)
+			continue
+		}
+		m.mu.Lock()
+		m.listeners[iface.Index] = l
+		m.mu.Unlock()
+
+		ctxlog.L(ctx).Infof("search: listening for IPv6 multicast search requests on %s", iface.Name)
+		go func() {
+			if err := l.serve(ctx, m.srv); err != nil && err != io.EOF {
+				ctxlog.L(ctx).Warnf("search: IPv6 listener on %s exited: %v", l.iface.Name, err)
+			}
+		}()
+	}
+
+	m.mu.Lock()
+	for idx, l := range m.listeners {
+		if !seen[idx] {
+			l.Close()
+			delete(m.listeners, idx)
+		}
+	}
+	m.mu.Unlock()
+	return nil
+}
+
+// sendBeacon sends beacon out of every known IPv6 interface socket so it
+// carries that interface's link-local source address.
+func (m *v6Manager) sendBeacon(ctx context.Context, beacon *proto.BeaconMessage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, l := range m.listeners {
+		if err := l.sendBeacon(ctx, beacon); err != nil {
+			ctxlog.L(ctx).Warnf("search: sending IPv6 beacon on %s: %v", l.iface.Name, err)
+			m.srv.telemetry.interfaceErrors.Add(ctx, 1, metric.WithAttributes(
+				attribute.String("reason", "ipv6_multicast"),
+				attribute.String("interface", l.iface.Name),
+			))
+			continue
+		}
+		m.srv.telemetry.beaconsSent.Add(ctx, 1)
+	}
+}
+
+func (m *v6Manager) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for idx, l := range m.listeners {
+		l.Close()
+		delete(m.listeners, idx)
+	}
+}
@@ -0,0 +1,84 @@
+package search
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/quentinmit/go-pvaccess/internal/proto"
+	"github.com/quentinmit/go-pvaccess/internal/registry"
+	"github.com/quentinmit/go-pvaccess/pvdata"
+)
+
+func TestSplitChannels(t *testing.T) {
+	reg := registry.New(registry.MapProvider{"foo": true, "bar": true})
+
+	channel := func(name string, instanceID pvdata.PVUInt) proto.SearchRequestChannel {
+		return proto.SearchRequestChannel{
+			SearchInstanceID: instanceID,
+			ChannelName:      pvdata.PVString(name),
+		}
+	}
+
+	for _, tt := range []struct {
+		name             string
+		channels         []proto.SearchRequestChannel
+		replyRequired    bool
+		wantFound        []pvdata.PVUInt
+		wantNotFound     []pvdata.PVUInt
+		wantSendNotFound bool
+	}{
+		{
+			name:             "all found, reply not required",
+			channels:         []proto.SearchRequestChannel{channel("foo", 1), channel("bar", 2)},
+			wantFound:        []pvdata.PVUInt{1, 2},
+			wantSendNotFound: false,
+		},
+		{
+			name:             "none found, reply not required: stay silent",
+			channels:         []proto.SearchRequestChannel{channel("nope", 1)},
+			wantNotFound:     []pvdata.PVUInt{1},
+			wantSendNotFound: false,
+		},
+		{
+			name:             "none found, reply required: send not-found",
+			channels:         []proto.SearchRequestChannel{channel("nope", 1)},
+			replyRequired:    true,
+			wantNotFound:     []pvdata.PVUInt{1},
+			wantSendNotFound: true,
+		},
+		{
+			name:             "mixed, reply not required: only the found response goes out",
+			channels:         []proto.SearchRequestChannel{channel("foo", 1), channel("nope", 2)},
+			wantFound:        []pvdata.PVUInt{1},
+			wantNotFound:     []pvdata.PVUInt{2},
+			wantSendNotFound: false,
+		},
+		{
+			name:             "mixed, reply required: both responses go out",
+			channels:         []proto.SearchRequestChannel{channel("foo", 1), channel("nope", 2)},
+			replyRequired:    true,
+			wantFound:        []pvdata.PVUInt{1},
+			wantNotFound:     []pvdata.PVUInt{2},
+			wantSendNotFound: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &proto.SearchRequest{Channels: tt.channels}
+			if tt.replyRequired {
+				req.Flags |= proto.SEARCH_REPLY_REQUIRED
+			}
+
+			found, notFound, sendNotFound := splitChannels(context.Background(), reg, req)
+			if !reflect.DeepEqual(found, tt.wantFound) {
+				t.Errorf("found = %v, want %v", found, tt.wantFound)
+			}
+			if !reflect.DeepEqual(notFound, tt.wantNotFound) {
+				t.Errorf("notFound = %v, want %v", notFound, tt.wantNotFound)
+			}
+			if sendNotFound != tt.wantSendNotFound {
+				t.Errorf("sendNotFound = %v, want %v", sendNotFound, tt.wantSendNotFound)
+			}
+		})
+	}
+}
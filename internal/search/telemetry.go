@@ -0,0 +1,134 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName identifies this package's spans and metrics to
+// whatever tracer/meter provider is in effect.
+const instrumentationName = "github.com/quentinmit/go-pvaccess/internal/search"
+
+const defaultServiceName = "go-pvaccess"
+
+// telemetry holds the tracer, meter, and instruments used to report
+// beacon and search lifecycle events. When nothing is configured via
+// WithOTLPEndpoint/OTEL_EXPORTER_OTLP_ENDPOINT, it's backed by the global
+// otel providers, which are no-ops until something else in the process
+// configures them — so Serve has zero overhead by default.
+type telemetry struct {
+	tracer trace.Tracer
+	meter  metric.Meter
+
+	beaconsSent     metric.Int64Counter
+	searchRequests  metric.Int64Counter
+	searchLatency   metric.Float64Histogram
+	interfaceErrors metric.Int64Counter
+
+	shutdown func(context.Context) error
+}
+
+// newTelemetry sets up tracing and metrics per o, returning a telemetry
+// whose shutdown func must be called when Serve returns to flush any
+// configured exporter.
+func newTelemetry(ctx context.Context, o *options) (*telemetry, error) {
+	tp, mp, shutdown, err := o.telemetryProviders(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &telemetry{
+		tracer:   tp.Tracer(instrumentationName),
+		meter:    mp.Meter(instrumentationName),
+		shutdown: shutdown,
+	}
+
+	var errs [4]error
+	t.beaconsSent, errs[0] = t.meter.Int64Counter(
+		"pvaccess.search.beacons_sent",
+		metric.WithDescription("Beacons transmitted: one per IPv4 broadcast burst, one per IPv6 interface"),
+	)
+	t.searchRequests, errs[1] = t.meter.Int64Counter(
+		"pvaccess.search.requests",
+		metric.WithDescription("Search requests received, labeled by whether any channel was found"),
+	)
+	t.searchLatency, errs[2] = t.meter.Float64Histogram(
+		"pvaccess.search.response_latency_seconds",
+		metric.WithDescription("Time from receiving a search request to sending its response(s)"),
+		metric.WithUnit("s"),
+	)
+	t.interfaceErrors, errs[3] = t.meter.Int64Counter(
+		"pvaccess.search.interface_send_errors",
+		metric.WithDescription("Errors sending a beacon or response out a specific interface"),
+	)
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// telemetryProviders resolves the trace.TracerProvider and
+// metric.MeterProvider Serve should use: an OTLP-exporting SDK provider if
+// an endpoint was configured (via WithOTLPEndpoint or
+// OTEL_EXPORTER_OTLP_ENDPOINT), or the global (no-op by default) providers
+// otherwise.
+func (o *options) telemetryProviders(ctx context.Context) (trace.TracerProvider, metric.MeterProvider, func(context.Context) error, error) {
+	endpoint := o.otlpEndpoint
+	if endpoint == "" {
+		endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+	if endpoint == "" {
+		return otel.GetTracerProvider(), otel.GetMeterProvider(), func(context.Context) error { return nil }, nil
+	}
+
+	serviceName := o.serviceName
+	if serviceName == "" {
+		serviceName = os.Getenv("OTEL_SERVICE_NAME")
+	}
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+	res, err := resource.New(ctx, resource.WithAttributes(semconv.ServiceName(serviceName)))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("search: building otel resource: %w", err)
+	}
+
+	traceExporter, err := otlptracegrpc.New(ctx, otlptracegrpc.WithEndpoint(endpoint), otlptracegrpc.WithInsecure())
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("search: creating OTLP trace exporter: %w", err)
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(traceExporter),
+		sdktrace.WithResource(res),
+	)
+
+	metricExporter, err := otlpmetricgrpc.New(ctx, otlpmetricgrpc.WithEndpoint(endpoint), otlpmetricgrpc.WithInsecure())
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("search: creating OTLP metric exporter: %w", err)
+	}
+	mp := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)),
+		sdkmetric.WithResource(res),
+	)
+
+	shutdown := func(ctx context.Context) error {
+		if err := tp.Shutdown(ctx); err != nil {
+			return err
+		}
+		return mp.Shutdown(ctx)
+	}
+	return tp, mp, shutdown, nil
+}